@@ -0,0 +1,76 @@
+/*
+ * Minio Cloud Storage, (C) 2016 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import "errors"
+
+// errInvalidLoginCredentials is returned by AuthRPCReceiver.Login when the
+// supplied username/password do not match this node's configured
+// credential.
+var errInvalidLoginCredentials = errors.New("invalid username or password")
+
+// globalLocalNodeID identifies this node in signed AuthTokens and in peer
+// skew/gossip tracking. It is set once at server startup from this node's
+// advertised address.
+var globalLocalNodeID string
+
+// AuthRPCReceiver is the RPC service registered on every node to handle
+// login and the clock-offset diagnostic call. Every other authenticated
+// RPC receiver in this process is reached only after a client has a valid
+// AuthToken from Login.
+type AuthRPCReceiver struct{}
+
+// Login authenticates username/password against this node's configured
+// credential and, on success, issues a freshly signed, expiring AuthToken.
+func (receiver *AuthRPCReceiver) Login(args *LoginRPCArgs, reply *LoginRPCReply) error {
+	if err := args.IsValid(); err != nil {
+		return err
+	}
+
+	cred := serverConfig.GetCredential()
+	if args.Username != cred.AccessKey || args.Password != cred.SecretKey {
+		return errInvalidLoginCredentials
+	}
+
+	// Now that the credential check has passed, args.Username is a trusted
+	// identity - fold this request's observed skew into its EWMA for real.
+	// IsValid only peeked at the window above, so a caller can't widen it
+	// with unverified samples by hitting Login with bogus passwords.
+	recordVerifiedSkew(args.Username, args.RequestTime)
+
+	token, expiry, err := signAuthToken(globalLocalNodeID, authTokenSecretKey())
+	if err != nil {
+		return err
+	}
+
+	reply.AuthToken = token
+	reply.TokenExpiry = expiry
+	reply.ServerOffset = globalClockSource.Offset()
+	return nil
+}
+
+// GetClockOffsets reports this node's current clockSource offset, plus any
+// peer offsets it has gossiped with, so operators can diagnose drift
+// without reading logs.
+func (receiver *AuthRPCReceiver) GetClockOffsets(args *ClockOffsetRPCArgs, reply *ClockOffsetRPCReply) error {
+	if err := args.IsAuthenticated(); err != nil {
+		return err
+	}
+
+	*reply = getClockOffsetReport()
+	return nil
+}
@@ -0,0 +1,244 @@
+/*
+ * Minio Cloud Storage, (C) 2016 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"container/list"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// authTokenVersion is bumped whenever the signed claims layout changes so
+// that a rolling upgrade can tell old and new tokens apart.
+const authTokenVersion = 1
+
+// authTokenTTL is the lifetime of a freshly issued AuthToken.
+const authTokenTTL = 15 * time.Minute
+
+// authTokenRefreshWindow is how far ahead of expiry a client should request
+// a fresh token instead of waiting to be rejected with errTokenExpired.
+const authTokenRefreshWindow = 1 * time.Minute
+
+// replayCacheSize bounds the number of (token, sequence) pairs the server
+// remembers per process. It is sized generously above the number of nodes
+// in any realistic cluster times a healthy in-flight request burst.
+const replayCacheSize = 10000
+
+// errTokenExpired is returned by IsAuthenticated when the AuthToken's
+// expiresAt has already passed. Callers should transparently re-login
+// instead of treating this as a hard failure.
+var errTokenExpired = errors.New("auth token has expired")
+
+// errReplayedRequest is returned by IsAuthenticated when the request
+// sequence number for a token has already been seen, or is lower than one
+// already seen - i.e. a captured request replayed by an attacker, or a
+// reordered/duplicated request. This is a hard failure.
+var errReplayedRequest = errors.New("request sequence number has already been used")
+
+// errTokenSignatureMismatch is returned when an AuthToken's signature does
+// not verify against the shared secret - either it was forged, or it was
+// signed with a secret key that has since rotated.
+var errTokenSignatureMismatch = errors.New("auth token signature mismatch")
+
+// errTokenMalformed is returned when an AuthToken does not parse as a
+// claims+signature pair at all.
+var errTokenMalformed = errors.New("auth token is malformed")
+
+// authTokenClaims are the fields signed into every AuthToken. The token
+// handed out by Login is the base64 encoding of the JSON claims, a ".", and
+// the base64 encoding of the HMAC-SHA256 signature over those claims -
+// deliberately similar in shape to a JWT, but scoped to what inter-node RPC
+// auth actually needs.
+type authTokenClaims struct {
+	NodeID    string `json:"nodeID"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	Nonce     string `json:"nonce"`
+	Version   int    `json:"v"`
+}
+
+// newAuthTokenNonce returns a fresh random nonce, unique per issued token so
+// that two tokens issued in the same second for the same node never
+// collide in the replay cache.
+func newAuthTokenNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// signAuthToken issues a new signed, expiring AuthToken for nodeID using
+// the cluster's shared credential secret key.
+func signAuthToken(nodeID string, secretKey string) (string, time.Time, error) {
+	nonce, err := newAuthTokenNonce()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(authTokenTTL)
+	claims := authTokenClaims{
+		NodeID:    nodeID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+		Nonce:     nonce,
+		Version:   authTokenVersion,
+	}
+
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	claimsEncoded := base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	token := claimsEncoded + "." + signClaims(claimsEncoded, secretKey)
+	return token, expiresAt, nil
+}
+
+// signClaims computes the base64-encoded HMAC-SHA256 of encodedClaims under
+// secretKey.
+func signClaims(encodedClaims string, secretKey string) string {
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write([]byte(encodedClaims))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyAuthToken parses token, checks its signature against secretKey and
+// returns the embedded claims. It does not check expiry or replay - callers
+// do that, so that they can return distinct errors for each failure mode.
+func verifyAuthToken(token string, secretKey string) (authTokenClaims, error) {
+	var claims authTokenClaims
+
+	dot := -1
+	for i := 0; i < len(token); i++ {
+		if token[i] == '.' {
+			dot = i
+			break
+		}
+	}
+	if dot < 0 || dot == len(token)-1 {
+		return claims, errTokenMalformed
+	}
+	encodedClaims, signature := token[:dot], token[dot+1:]
+
+	expectedSignature := signClaims(encodedClaims, secretKey)
+	if subtle.ConstantTimeCompare([]byte(signature), []byte(expectedSignature)) != 1 {
+		return claims, errTokenSignatureMismatch
+	}
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(encodedClaims)
+	if err != nil {
+		return claims, errTokenMalformed
+	}
+	if err = json.Unmarshal(claimsJSON, &claims); err != nil {
+		return claims, errTokenMalformed
+	}
+
+	return claims, nil
+}
+
+// replaySeenEntry is the value stored per token in the replay cache - the
+// highest sequence number observed so far for that token.
+type replaySeenEntry struct {
+	token   string
+	lastSeq uint64
+}
+
+// replayCache is a bounded LRU of the highest sequence number seen for each
+// recently active AuthToken, used to reject replayed or reordered RPC
+// requests even when they fall inside the request-time skew window.
+type replayCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	index    map[string]*list.Element
+}
+
+func newReplayCache(capacity int) *replayCache {
+	return &replayCache{
+		capacity: capacity,
+		ll:       list.New(),
+		index:    make(map[string]*list.Element),
+	}
+}
+
+// checkAndRecord validates that seq is strictly greater than the last
+// sequence number seen for token, then records it. A non-increasing
+// sequence number indicates a replayed or reordered request.
+func (r *replayCache) checkAndRecord(token string, seq uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if elem, ok := r.index[token]; ok {
+		entry := elem.Value.(*replaySeenEntry)
+		if seq <= entry.lastSeq {
+			return errReplayedRequest
+		}
+		entry.lastSeq = seq
+		r.ll.MoveToFront(elem)
+		return nil
+	}
+
+	entry := &replaySeenEntry{token: token, lastSeq: seq}
+	elem := r.ll.PushFront(entry)
+	r.index[token] = elem
+
+	if r.ll.Len() > r.capacity {
+		oldest := r.ll.Back()
+		if oldest != nil {
+			r.ll.Remove(oldest)
+			delete(r.index, oldest.Value.(*replaySeenEntry).token)
+		}
+	}
+
+	return nil
+}
+
+// globalReplayCache tracks the last seen sequence number per AuthToken
+// across all authenticated RPC handlers in this process.
+var globalReplayCache = newReplayCache(replayCacheSize)
+
+// authTokenSecretKey returns the shared secret used to sign and verify
+// AuthTokens. All nodes in a cluster must be configured with the same
+// credential for tokens to validate across peers.
+func authTokenSecretKey() string {
+	return serverConfig.GetCredential().SecretKey
+}
+
+// requestSequence hands out a monotonically increasing sequence number
+// per authenticated connection for use in AuthRPCArgs.Sequence.
+type requestSequence struct {
+	n uint64
+}
+
+func (s *requestSequence) next() uint64 {
+	s.n++
+	return s.n
+}
+
+func (e authTokenClaims) String() string {
+	return fmt.Sprintf("AuthToken{nodeID: %s, issuedAt: %d, expiresAt: %d}", e.NodeID, e.IssuedAt, e.ExpiresAt)
+}
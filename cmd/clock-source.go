@@ -0,0 +1,294 @@
+/*
+ * Minio Cloud Storage, (C) 2016 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// clockSource abstracts how a node determines "now" for the purposes of
+// validating RPC request timestamps. The default systemClockSource trusts
+// the local system clock outright; sntpClockSource and
+// peerGossipClockSource instead maintain a correction on top of it so a
+// cluster keeps working through ordinary NTP drift instead of hard-failing
+// every RPC from the node that's out of sync.
+type clockSource interface {
+	// Now returns the current, offset-adjusted time.
+	Now() time.Time
+
+	// Offset returns the correction currently being applied on top of the
+	// system clock to arrive at Now().
+	Offset() time.Duration
+}
+
+// globalClockSource is consulted by isRequestTimeAllowed instead of calling
+// time.Now() directly. It defaults to the system clock and can be swapped
+// out for an sntpClockSource or peerGossipClockSource at startup.
+var globalClockSource clockSource = systemClockSource{}
+
+// systemClockSource is the zero-configuration default - it applies no
+// correction at all.
+type systemClockSource struct{}
+
+func (systemClockSource) Now() time.Time        { return time.Now().UTC() }
+func (systemClockSource) Offset() time.Duration { return 0 }
+
+// sntpServerTimeout bounds how long a single NTP query is allowed to take
+// before it is considered failed and skipped for this round.
+const sntpServerTimeout = 5 * time.Second
+
+// sntpClockSource periodically queries a list of configured NTP servers and
+// maintains a rolling offset that is applied on top of the system clock.
+type sntpClockSource struct {
+	servers  []string
+	interval time.Duration
+	doneCh   chan struct{}
+
+	mu     sync.RWMutex
+	offset time.Duration
+}
+
+// newSNTPClockSource starts a background goroutine that refreshes the
+// clock offset against servers every interval.
+func newSNTPClockSource(servers []string, interval time.Duration) *sntpClockSource {
+	s := &sntpClockSource{
+		servers:  servers,
+		interval: interval,
+		doneCh:   make(chan struct{}),
+	}
+	go s.loop()
+	return s
+}
+
+func (s *sntpClockSource) Now() time.Time {
+	s.mu.RLock()
+	offset := s.offset
+	s.mu.RUnlock()
+	return time.Now().UTC().Add(offset)
+}
+
+func (s *sntpClockSource) Offset() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.offset
+}
+
+// Stop terminates the background refresh goroutine.
+func (s *sntpClockSource) Stop() {
+	close(s.doneCh)
+}
+
+func (s *sntpClockSource) loop() {
+	s.refresh()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.refresh()
+		case <-s.doneCh:
+			return
+		}
+	}
+}
+
+// refresh queries every configured server and keeps the median of the
+// offsets that could be measured, which is robust against a single
+// unreachable or misbehaving server.
+func (s *sntpClockSource) refresh() {
+	var offsets []time.Duration
+	for _, server := range s.servers {
+		offset, err := querySNTPOffset(server, sntpServerTimeout)
+		if err != nil {
+			continue
+		}
+		offsets = append(offsets, offset)
+	}
+	if len(offsets) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	s.offset = medianDuration(offsets)
+	s.mu.Unlock()
+}
+
+// querySNTPOffset sends a minimal SNTP (RFC 4330) client request to server
+// and returns how far the local clock is from the server's, computed from
+// the four standard NTP timestamps using the usual round-trip formula:
+//
+//	offset = ((T2 - T1) + (T3 - T4)) / 2
+func querySNTPOffset(server string, timeout time.Duration) (time.Duration, error) {
+	conn, err := net.DialTimeout("udp", server, timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return 0, err
+	}
+
+	// A client request packet: all zero except the first byte, which sets
+	// LI = 0 (no warning), VN = 3 (NTPv3), Mode = 3 (client).
+	request := make([]byte, 48)
+	request[0] = 0x1B
+
+	t1 := time.Now()
+	if _, err = conn.Write(request); err != nil {
+		return 0, err
+	}
+
+	response := make([]byte, 48)
+	if _, err = conn.Read(response); err != nil {
+		return 0, err
+	}
+	t4 := time.Now()
+
+	t2 := ntpToTime(response[32:40])
+	t3 := ntpToTime(response[40:48])
+
+	offset := t2.Sub(t1) + t3.Sub(t4)
+	return offset / 2, nil
+}
+
+// ntpEpochOffset is the number of seconds between the NTP epoch
+// (1900-01-01) and the Unix epoch (1970-01-01).
+const ntpEpochOffset = 2208988800
+
+// ntpToTime decodes a 64-bit NTP timestamp (32-bit seconds since 1900,
+// 32-bit fraction) into a time.Time.
+func ntpToTime(b []byte) time.Time {
+	seconds := binary.BigEndian.Uint32(b[0:4])
+	fraction := binary.BigEndian.Uint32(b[4:8])
+	nanos := (int64(fraction) * 1e9) >> 32
+	return time.Unix(int64(seconds)-ntpEpochOffset, nanos).UTC()
+}
+
+// peerGossipClockSource computes a corrected time from each peer's
+// self-reported clock offset, piggybacked onto authenticated RPC replies
+// via AuthRPCReply.ServerOffset, combined into a cluster-wide median. The
+// median is robust against a handful of peers that are themselves
+// badly drifted.
+type peerGossipClockSource struct {
+	mu            sync.RWMutex
+	peerOffsets   map[string]time.Duration
+	clusterOffset time.Duration
+}
+
+// newPeerGossipClockSource returns an empty peerGossipClockSource that
+// applies no correction until peer offsets start arriving.
+func newPeerGossipClockSource() *peerGossipClockSource {
+	return &peerGossipClockSource{
+		peerOffsets: make(map[string]time.Duration),
+	}
+}
+
+func (p *peerGossipClockSource) Now() time.Time {
+	p.mu.RLock()
+	offset := p.clusterOffset
+	p.mu.RUnlock()
+	return time.Now().UTC().Add(offset)
+}
+
+func (p *peerGossipClockSource) Offset() time.Duration {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.clusterOffset
+}
+
+// recordPeerOffset records the latest self-reported offset from peerID and
+// recomputes the cluster median offset.
+func (p *peerGossipClockSource) recordPeerOffset(peerID string, offset time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.peerOffsets[peerID] = offset
+
+	offsets := make([]time.Duration, 0, len(p.peerOffsets))
+	for _, o := range p.peerOffsets {
+		offsets = append(offsets, o)
+	}
+	p.clusterOffset = medianDuration(offsets)
+}
+
+// offsetReport describes one peer's last-known clock offset, for the admin
+// RPC that surfaces drift without requiring an operator to read logs.
+type offsetReport struct {
+	Peer   string
+	Offset time.Duration
+}
+
+// report returns a snapshot of every peer offset currently known, sorted by
+// peer name for stable output.
+func (p *peerGossipClockSource) report() []offsetReport {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	reports := make([]offsetReport, 0, len(p.peerOffsets))
+	for peer, offset := range p.peerOffsets {
+		reports = append(reports, offsetReport{Peer: peer, Offset: offset})
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Peer < reports[j].Peer })
+	return reports
+}
+
+// recordPeerGossip feeds a peer's self-reported clock offset - piggybacked
+// on an authenticated RPC reply as AuthRPCReply.ServerOffset - into
+// globalClockSource, if it's currently configured as a
+// peerGossipClockSource. It is a no-op under any other clockSource.
+func recordPeerGossip(peerID string, offset time.Duration) {
+	gossip, ok := globalClockSource.(*peerGossipClockSource)
+	if !ok {
+		return
+	}
+	gossip.recordPeerOffset(peerID, offset)
+}
+
+// getClockOffsetReport builds a ClockOffsetRPCReply describing this node's
+// current clock offset and, when globalClockSource is a
+// peerGossipClockSource, every peer offset gossiped so far. It backs the
+// ClockOffsetRPCArgs admin RPC.
+func getClockOffsetReport() ClockOffsetRPCReply {
+	reply := ClockOffsetRPCReply{LocalOffset: globalClockSource.Offset()}
+
+	gossip, ok := globalClockSource.(*peerGossipClockSource)
+	if !ok {
+		return reply
+	}
+
+	for _, r := range gossip.report() {
+		reply.Peers = append(reply.Peers, PeerClockOffset{Peer: r.Peer, Offset: r.Offset})
+	}
+	return reply
+}
+
+// medianDuration returns the median of a non-empty slice of durations.
+func medianDuration(durations []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid]
+	}
+	return (sorted[mid-1] + sorted[mid]) / 2
+}
@@ -0,0 +1,99 @@
+/*
+ * Minio Cloud Storage, (C) 2016 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestMedianDurationOdd(t *testing.T) {
+	got := medianDuration([]time.Duration{3 * time.Second, 1 * time.Second, 2 * time.Second})
+	if want := 2 * time.Second; got != want {
+		t.Fatalf("medianDuration = %s, want %s", got, want)
+	}
+}
+
+func TestMedianDurationEven(t *testing.T) {
+	got := medianDuration([]time.Duration{1 * time.Second, 2 * time.Second, 3 * time.Second, 4 * time.Second})
+	if want := 2500 * time.Millisecond; got != want {
+		t.Fatalf("medianDuration = %s, want %s", got, want)
+	}
+}
+
+func TestMedianDurationSingle(t *testing.T) {
+	got := medianDuration([]time.Duration{7 * time.Second})
+	if want := 7 * time.Second; got != want {
+		t.Fatalf("medianDuration = %s, want %s", got, want)
+	}
+}
+
+func TestNTPTimeRoundTrip(t *testing.T) {
+	want := time.Date(2026, 7, 27, 12, 0, 0, 500000000, time.UTC)
+
+	seconds := uint32(want.Unix() + ntpEpochOffset)
+	fraction := uint32((int64(want.Nanosecond()) << 32) / 1e9)
+
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint32(b[0:4], seconds)
+	binary.BigEndian.PutUint32(b[4:8], fraction)
+
+	got := ntpToTime(b)
+	if diff := got.Sub(want); diff > time.Millisecond || diff < -time.Millisecond {
+		t.Fatalf("ntpToTime round trip = %s, want %s (diff %s)", got, want, diff)
+	}
+}
+
+func TestPeerGossipClockSourceMedianOffset(t *testing.T) {
+	gossip := newPeerGossipClockSource()
+
+	gossip.recordPeerOffset("peer-a", 1*time.Second)
+	gossip.recordPeerOffset("peer-b", 3*time.Second)
+
+	if got := gossip.Offset(); got != 2*time.Second {
+		t.Fatalf("Offset() = %s, want %s", got, 2*time.Second)
+	}
+
+	reports := gossip.report()
+	if len(reports) != 2 {
+		t.Fatalf("report() returned %d entries, want 2", len(reports))
+	}
+}
+
+func TestRecordPeerGossipIgnoredForSystemClock(t *testing.T) {
+	prev := globalClockSource
+	defer func() { globalClockSource = prev }()
+
+	globalClockSource = systemClockSource{}
+	// Must not panic nor alter anything when the configured clock source
+	// isn't peer-gossip based.
+	recordPeerGossip("peer-a", 5*time.Second)
+}
+
+func TestRecordPeerGossipFeedsConfiguredSource(t *testing.T) {
+	prev := globalClockSource
+	defer func() { globalClockSource = prev }()
+
+	gossip := newPeerGossipClockSource()
+	globalClockSource = gossip
+
+	recordPeerGossip("peer-a", 4*time.Second)
+	if got := gossip.Offset(); got != 4*time.Second {
+		t.Fatalf("Offset() after recordPeerGossip = %s, want %s", got, 4*time.Second)
+	}
+}
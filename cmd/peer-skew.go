@@ -0,0 +1,180 @@
+/*
+ * Minio Cloud Storage, (C) 2016 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// skewEWMAAlpha weighs each new skew sample against the running average.
+// Chosen low enough that a single noisy sample can't swing the accepted
+// window, while still tracking genuine drift within a few RPCs.
+const skewEWMAAlpha = 0.2
+
+// maxSkewWindow is the hard cap on how far peerSkewTracker will widen the
+// accepted request-time window for any one peer, however much it appears to
+// be drifting. Past this the peer is failed rather than indefinitely
+// tolerated.
+const maxSkewWindow = 30 * time.Second
+
+// skewWarnThreshold is the EWMA skew above which peerSkewTracker logs a
+// warning so operators notice drift building up well before it reaches
+// maxSkewWindow.
+const skewWarnThreshold = 10 * time.Second
+
+// peerSkewCacheSize bounds the number of distinct peers peerSkewTracker
+// remembers an EWMA for. Pre-login, peer is the attacker-controlled
+// Username field of LoginRPCArgs, so this must be bounded the same way
+// globalReplayCache is, or a flood of one-off usernames is an unbounded
+// memory DoS.
+const peerSkewCacheSize = 10000
+
+// skewEntry is the value tracked per peer - its current EWMA skew.
+type skewEntry struct {
+	peer string
+	ewma time.Duration
+}
+
+// peerSkewTracker maintains an exponentially-weighted moving average of the
+// observed clock skew for each peer, and uses it to dynamically widen the
+// request-time acceptance window beyond the static rpcSkewTimeAllowed bound.
+// Without this, a single node with a few seconds of permanent drift has
+// every RPC it sends rejected cluster-wide instead of just that node being
+// flagged. It is a bounded LRU, like globalReplayCache, so it can't be
+// grown without limit by an unauthenticated caller.
+type peerSkewTracker struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	index    map[string]*list.Element
+}
+
+// newPeerSkewTracker returns an empty peerSkewTracker bounded to capacity
+// distinct peers.
+func newPeerSkewTracker(capacity int) *peerSkewTracker {
+	return &peerSkewTracker{
+		capacity: capacity,
+		ll:       list.New(),
+		index:    make(map[string]*list.Element),
+	}
+}
+
+// globalPeerSkewTracker backs the dynamic window used by
+// isRequestTimeAllowed.
+var globalPeerSkewTracker = newPeerSkewTracker(peerSkewCacheSize)
+
+// windowFor returns the currently accepted request-time skew window for
+// peer, based on the EWMA skew observed for peer *before* this call, then
+// folds observedSkew into that EWMA for next time.
+//
+// The window must be derived from the prior average, not the one updated
+// with observedSkew: folding in the unverified sample first would make the
+// window always expand to cover whatever skew was just presented, letting
+// a first-ever (and, pre-login, attacker-chosen) peer name bypass the
+// check with any RequestTime at all.
+//
+// The returned window is never less than rpcSkewTimeAllowed, nor more than
+// maxSkewWindow.
+func (t *peerSkewTracker) windowFor(peer string, observedSkew time.Duration) time.Duration {
+	if observedSkew < 0 {
+		observedSkew = -observedSkew
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var priorAvg time.Duration
+	elem, ok := t.index[peer]
+	if ok {
+		priorAvg = elem.Value.(*skewEntry).ewma
+	}
+
+	window := rpcSkewTimeAllowed + priorAvg
+	if window > maxSkewWindow {
+		window = maxSkewWindow
+	}
+
+	newAvg := observedSkew
+	if ok {
+		newAvg = time.Duration(skewEWMAAlpha*float64(observedSkew) + (1-skewEWMAAlpha)*float64(priorAvg))
+	}
+	t.record(peer, elem, newAvg)
+
+	if newAvg > skewWarnThreshold {
+		errorIf(nil, "peer %s clock skew (%s moving average) exceeds %s - check NTP configuration", peer, newAvg, skewWarnThreshold)
+	}
+
+	return window
+}
+
+// peekWindowFor returns the window currently accepted for peer, based on
+// whatever EWMA is already on record, without touching it - no update, no
+// insertion, no LRU promotion. Used for pre-authentication checks where the
+// peer identity hasn't been verified yet, so the caller must not be able to
+// influence their own window by calling this.
+func (t *peerSkewTracker) peekWindowFor(peer string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var avg time.Duration
+	if elem, ok := t.index[peer]; ok {
+		avg = elem.Value.(*skewEntry).ewma
+	}
+
+	window := rpcSkewTimeAllowed + avg
+	if window > maxSkewWindow {
+		window = maxSkewWindow
+	}
+	return window
+}
+
+// record updates elem (or inserts a fresh entry for peer if elem is nil)
+// with ewma, marks it most-recently-used, and evicts the least-recently-used
+// entry if capacity is exceeded. Callers must hold t.mu.
+func (t *peerSkewTracker) record(peer string, elem *list.Element, ewma time.Duration) {
+	if elem != nil {
+		elem.Value.(*skewEntry).ewma = ewma
+		t.ll.MoveToFront(elem)
+		return
+	}
+
+	entry := &skewEntry{peer: peer, ewma: ewma}
+	inserted := t.ll.PushFront(entry)
+	t.index[peer] = inserted
+
+	if t.ll.Len() > t.capacity {
+		oldest := t.ll.Back()
+		if oldest != nil {
+			t.ll.Remove(oldest)
+			delete(t.index, oldest.Value.(*skewEntry).peer)
+		}
+	}
+}
+
+// skewFor returns the last EWMA skew recorded for peer, or zero if none has
+// been observed yet.
+func (t *peerSkewTracker) skewFor(peer string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	elem, ok := t.index[peer]
+	if !ok {
+		return 0
+	}
+	return elem.Value.(*skewEntry).ewma
+}
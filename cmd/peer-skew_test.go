@@ -0,0 +1,110 @@
+/*
+ * Minio Cloud Storage, (C) 2016 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+// TestPeerSkewTrackerFirstSightIgnoresSample verifies that the window
+// returned for a peer's first-ever sample is bounded by the static
+// rpcSkewTimeAllowed baseline only, regardless of how large that first
+// sample is - an attacker picking an arbitrary first RequestTime for a
+// never-before-seen (pre-login, attacker-controlled) peer name must not be
+// able to inflate their own acceptance window.
+func TestPeerSkewTrackerFirstSightIgnoresSample(t *testing.T) {
+	tracker := newPeerSkewTracker(peerSkewCacheSize)
+
+	window := tracker.windowFor("attacker", 20*time.Second)
+	if window != rpcSkewTimeAllowed {
+		t.Fatalf("first-sight window = %s, want %s (static baseline, ignoring the unverified sample)", window, rpcSkewTimeAllowed)
+	}
+
+	// The sample should still have been folded in for next time.
+	if skew := tracker.skewFor("attacker"); skew != 20*time.Second {
+		t.Fatalf("skewFor after first sample = %s, want %s", skew, 20*time.Second)
+	}
+}
+
+// TestPeerSkewTrackerWindowWidensWithDrift verifies that after repeated
+// consistent samples a peer's window is wider than the rpcSkewTimeAllowed
+// baseline and no wider than maxSkewWindow.
+func TestPeerSkewTrackerWindowWidensWithDrift(t *testing.T) {
+	tracker := newPeerSkewTracker(peerSkewCacheSize)
+
+	const sample = 5 * time.Second
+	var window time.Duration
+	for i := 0; i < 100; i++ {
+		window = tracker.windowFor("drifting-node", sample)
+	}
+
+	if window <= rpcSkewTimeAllowed {
+		t.Fatalf("window after repeated drift = %s, want > baseline %s", window, rpcSkewTimeAllowed)
+	}
+	if window > maxSkewWindow {
+		t.Fatalf("window = %s, want capped at %s", window, maxSkewWindow)
+	}
+}
+
+// TestPeerSkewTrackerPeekDoesNotUpdate verifies that peekWindowFor neither
+// changes a peer's recorded EWMA nor inserts an unseen peer into the LRU -
+// required for LoginRPCArgs.IsValid, which calls this with an
+// unverified, attacker-supplied username before credentials are checked.
+func TestPeerSkewTrackerPeekDoesNotUpdate(t *testing.T) {
+	tracker := newPeerSkewTracker(peerSkewCacheSize)
+
+	window := tracker.peekWindowFor("attacker")
+	if window != rpcSkewTimeAllowed {
+		t.Fatalf("peekWindowFor on unseen peer = %s, want %s", window, rpcSkewTimeAllowed)
+	}
+	if _, ok := tracker.index["attacker"]; ok {
+		t.Fatalf("peekWindowFor must not insert an entry for an unseen peer")
+	}
+
+	// Drive a real sample in for a different, legitimate peer so the map
+	// isn't empty, then hammer peekWindowFor for it and confirm its EWMA
+	// stays at that one recorded sample.
+	tracker.windowFor("legit-peer", 1*time.Second)
+	for i := 0; i < 50; i++ {
+		tracker.peekWindowFor("legit-peer")
+	}
+	if got := tracker.skewFor("legit-peer"); got != 1*time.Second {
+		t.Fatalf("repeated peekWindowFor calls altered EWMA: got %s, want %s", got, 1*time.Second)
+	}
+}
+
+// TestPeerSkewTrackerBoundedCapacity verifies that the tracker evicts the
+// least-recently-used peer once its capacity is exceeded, so an unbounded
+// stream of attacker-chosen peer names cannot grow memory without limit.
+func TestPeerSkewTrackerBoundedCapacity(t *testing.T) {
+	tracker := newPeerSkewTracker(2)
+
+	tracker.windowFor("peer-a", time.Second)
+	tracker.windowFor("peer-b", time.Second)
+	tracker.windowFor("peer-c", time.Second) // evicts peer-a (least recently used)
+
+	if tracker.ll.Len() != 2 {
+		t.Fatalf("tracker size = %d, want 2", tracker.ll.Len())
+	}
+	if _, ok := tracker.index["peer-a"]; ok {
+		t.Fatalf("peer-a should have been evicted")
+	}
+	if _, ok := tracker.index["peer-c"]; !ok {
+		t.Fatalf("peer-c should be present")
+	}
+}
@@ -22,15 +22,51 @@ import (
 	"github.com/minio/dsync"
 )
 
-// Allow any RPC call request time should be no more/less than 3 seconds.
-// 3 seconds is chosen arbitrarily.
+// Allow any RPC call request time to be no more/less than 3 seconds off of
+// this node's clock, as a baseline. peer is identified by their node ID (or
+// username, pre-login) so that a peer which is consistently a few seconds
+// off only widens its own window - see peerSkewTracker - instead of every
+// peer needing to be re-bounded.
 const rpcSkewTimeAllowed = 3 * time.Second
 
-func isRequestTimeAllowed(requestTime time.Time) bool {
-	// Check whether request time is within acceptable skew time.
-	utcNow := time.Now().UTC()
-	return !(requestTime.Sub(utcNow) > rpcSkewTimeAllowed ||
-		utcNow.Sub(requestTime) > rpcSkewTimeAllowed)
+// isRequestTimeAllowed checks requestTime against peer's current skew
+// window and feeds this sample into peer's EWMA for next time. Only call
+// this for a peer identity that has already been verified - e.g. the
+// signature-verified NodeID from an AuthToken - since it updates shared
+// state keyed on peer.
+func isRequestTimeAllowed(peer string, requestTime time.Time) bool {
+	// globalClockSource.Now() is the system clock unless an sntpClockSource
+	// or peerGossipClockSource has been configured to correct for drift.
+	utcNow := globalClockSource.Now()
+	skew := requestTime.Sub(utcNow)
+
+	window := globalPeerSkewTracker.windowFor(peer, skew)
+	return !(skew > window || -skew > window)
+}
+
+// isRequestTimeAllowedPreAuth checks requestTime against peer's *current*
+// skew window without feeding this sample into the EWMA.
+//
+// This exists for LoginRPCArgs.IsValid, which runs before credentials are
+// checked and is keyed on the attacker-controlled Username field. Folding
+// an unverified sample in here would let an unauthenticated caller drive
+// up the skew window for a legitimate, shared access key simply by
+// repeatedly hitting Login with bogus passwords and wild RequestTime
+// values. Once Login's credential check succeeds, it calls
+// recordVerifiedSkew to fold the now-trusted sample in for real.
+func isRequestTimeAllowedPreAuth(peer string, requestTime time.Time) bool {
+	utcNow := globalClockSource.Now()
+	skew := requestTime.Sub(utcNow)
+
+	window := globalPeerSkewTracker.peekWindowFor(peer)
+	return !(skew > window || -skew > window)
+}
+
+// recordVerifiedSkew feeds requestTime's observed skew into peer's EWMA.
+// Call only once peer's identity is verified - see isRequestTimeAllowedPreAuth.
+func recordVerifiedSkew(peer string, requestTime time.Time) {
+	skew := requestTime.Sub(globalClockSource.Now())
+	globalPeerSkewTracker.windowFor(peer, skew)
 }
 
 // AuthRPCArgs represents minimum required arguments to make any authenticated RPC call.
@@ -41,6 +77,12 @@ type AuthRPCArgs struct {
 	// Request time to be verified by the server for every RPC call.
 	// This is an addition check over Authentication token for time drifting.
 	RequestTime time.Time
+
+	// Sequence is a per-connection, monotonically increasing counter set by
+	// the client on every RPC call. Together with AuthToken it lets the
+	// server detect a captured request being replayed, even when it is
+	// replayed inside the allowed clock-skew window.
+	Sequence uint64
 }
 
 // SetAuthToken - sets the token to the supplied value.
@@ -53,24 +95,50 @@ func (args *AuthRPCArgs) SetRequestTime(requestTime time.Time) {
 	args.RequestTime = requestTime
 }
 
+// SetSequence - sets the per-connection request sequence number to the
+// supplied value.
+func (args *AuthRPCArgs) SetSequence(seq uint64) {
+	args.Sequence = seq
+}
+
 // IsAuthenticated - validated whether this auth RPC args are already authenticated or not.
 func (args AuthRPCArgs) IsAuthenticated() error {
-	// Check whether the token is valid
-	if !isAuthTokenValid(args.AuthToken) {
-		return errInvalidToken
+	// Verify the token signature and pull out its claims.
+	claims, err := verifyAuthToken(args.AuthToken, authTokenSecretKey())
+	if err != nil {
+		return err
+	}
+
+	// Reject tokens past their expiresAt - the caller should re-login and
+	// retry rather than treat this as a hard authentication failure.
+	if time.Now().UTC().After(time.Unix(claims.ExpiresAt, 0).UTC()) {
+		return errTokenExpired
 	}
 
 	// Check if the request time is within the allowed skew limit.
-	if !isRequestTimeAllowed(args.RequestTime) {
+	if !isRequestTimeAllowed(claims.NodeID, args.RequestTime) {
 		return errServerTimeMismatch
 	}
 
+	// Reject a sequence number we have already seen (or one lower than the
+	// highest already seen) for this token - a replayed or reordered
+	// request.
+	if err = globalReplayCache.checkAndRecord(args.AuthToken, args.Sequence); err != nil {
+		return err
+	}
+
 	// Good to go.
 	return nil
 }
 
 // AuthRPCReply represents minimum required reply for any authenticated RPC call.
-type AuthRPCReply struct{}
+type AuthRPCReply struct {
+	// ServerOffset is this node's current clockSource offset from its raw
+	// system clock. Callers running a peerGossipClockSource feed this back
+	// in via recordPeerOffset so the cluster converges on a median-adjusted
+	// notion of "now" without depending on any single node's NTP setup.
+	ServerOffset time.Duration
+}
 
 // LoginRPCArgs - login username and password for RPC.
 type LoginRPCArgs struct {
@@ -87,7 +155,12 @@ func (args LoginRPCArgs) IsValid() error {
 		return errServerVersionMismatch
 	}
 
-	if !isRequestTimeAllowed(args.RequestTime) {
+	// Pre-login there is no NodeID claim yet to key the skew tracker on, so
+	// the username stands in for peer identity - but args.Username is not
+	// yet verified, so this only peeks at the current window rather than
+	// updating it. AuthRPCReceiver.Login records the sample for real once
+	// the credential check passes.
+	if !isRequestTimeAllowedPreAuth(args.Username, args.RequestTime) {
 		return errServerTimeMismatch
 	}
 
@@ -98,6 +171,17 @@ func (args LoginRPCArgs) IsValid() error {
 // with subsequent requests.
 type LoginRPCReply struct {
 	AuthToken string
+
+	// TokenExpiry is when AuthToken stops being accepted by
+	// AuthRPCArgs.IsAuthenticated. Clients should request a new token via
+	// authTokenRefreshWindow before this time rather than wait to be
+	// rejected with errTokenExpired.
+	TokenExpiry time.Time
+
+	// ServerOffset is this node's current clockSource offset, piggybacked
+	// on the Login reply for the same reason AuthRPCReply carries one -
+	// LoginRPCReply does not embed AuthRPCReply, so it needs its own copy.
+	ServerOffset time.Duration
 }
 
 // LockArgs represents arguments for any authenticated lock RPC call.
@@ -109,3 +193,24 @@ type LockArgs struct {
 func newLockArgs(args dsync.LockArgs) LockArgs {
 	return LockArgs{dsyncLockArgs: args}
 }
+
+// ClockOffsetRPCArgs represents the (empty) arguments for the admin RPC
+// that reports this node's currently observed clock offsets, so operators
+// can diagnose drift without reading logs.
+type ClockOffsetRPCArgs struct {
+	AuthRPCArgs
+}
+
+// PeerClockOffset is one peer's last-known clock offset as observed by this
+// node's peerGossipClockSource, if configured.
+type PeerClockOffset struct {
+	Peer   string
+	Offset time.Duration
+}
+
+// ClockOffsetRPCReply reports this node's own clockSource offset along with
+// every peer offset it has gossiped with other nodes.
+type ClockOffsetRPCReply struct {
+	LocalOffset time.Duration
+	Peers       []PeerClockOffset
+}